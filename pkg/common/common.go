@@ -0,0 +1,37 @@
+// Package common holds types shared between RootlessKit's parent and
+// child processes across the network and port drivers.
+package common
+
+// NetworkMessage is propagated from the parent's network driver to the
+// child's, over the pipe used for the parent-child handshake.
+type NetworkMessage struct {
+	Dev     string
+	MTU     int
+	IP      string
+	Netmask int
+	Gateway string
+	DNS     string
+
+	// IP6, Netmask6, Gateway6, and DNS6 carry the IPv6 counterparts of the
+	// fields above. They are left zero-valued when IPv6 is not enabled.
+	IP6      string
+	Netmask6 int
+	Gateway6 string
+	DNS6     string
+}
+
+// Seq returns a function that calls fns in order, stopping at (and
+// returning) the first error.
+func Seq(fns []func() error) func() error {
+	return func() error {
+		for _, f := range fns {
+			if f == nil {
+				continue
+			}
+			if err := f(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -1,13 +1,16 @@
 package slirp4netns
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -18,6 +21,15 @@ import (
 	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
 )
 
+// readyFdTimeout bounds how long ConfigureNetwork waits for slirp4netns to
+// signal readiness via --ready-fd before giving up.
+const readyFdTimeout = 10 * time.Second
+
+// queryConfigTimeout bounds how long queryConfig waits for a get_config
+// response before giving up and letting ConfigureNetwork fall back to its
+// computed defaults.
+const queryConfigTimeout = 10 * time.Second
+
 type Features struct {
 	// SupportsCIDR --cidr (v0.3.0)
 	SupportsCIDR bool
@@ -27,6 +39,16 @@ type Features struct {
 	SupportsAPISocket bool
 	// SupportsCreateSandbox --create-sandbox (v0.4.0)
 	SupportsCreateSandbox bool
+	// SupportsEnableIPv6 --enable-ipv6 (v1.1.0)
+	SupportsEnableIPv6 bool
+	// SupportsEnableSeccomp --enable-seccomp (v1.1.0)
+	SupportsEnableSeccomp bool
+	// SupportsOutboundAddr --outbound-addr=<ipv4-or-iface> (v1.1.0)
+	SupportsOutboundAddr bool
+	// SupportsOutboundAddr6 --outbound-addr6=<ipv6-or-iface> (v1.1.0)
+	SupportsOutboundAddr6 bool
+	// SupportsReadyFd --ready-fd (v0.4.0)
+	SupportsReadyFd bool
 }
 
 func DetectFeatures(binary string) (*Features, error) {
@@ -51,10 +73,42 @@ func DetectFeatures(binary string) (*Features, error) {
 		SupportsDisableHostLoopback: strings.Contains(s, "--disable-host-loopback"),
 		SupportsAPISocket:           strings.Contains(s, "--api-socket"),
 		SupportsCreateSandbox:       strings.Contains(s, "--create-sandbox"),
+		SupportsEnableIPv6:          strings.Contains(s, "--enable-ipv6"),
+		SupportsEnableSeccomp:       strings.Contains(s, "--enable-seccomp"),
+		SupportsOutboundAddr:        strings.Contains(s, "--outbound-addr"),
+		SupportsOutboundAddr6:       strings.Contains(s, "--outbound-addr6"),
+		SupportsReadyFd:             strings.Contains(s, "--ready-fd"),
 	}
 	return &f, nil
 }
 
+// Opts holds the parent driver knobs that were added after RootlessKit's
+// original six NewParentDriver parameters. Bundling them here lets the
+// set grow without breaking NewParentDriver's signature on every addition.
+type Opts struct {
+	// EnableIPv6 and IP6Net are supported only for slirp4netns v1.1.0+.
+	// IP6Net MUST be nil when EnableIPv6 is false.
+	EnableIPv6 bool
+	IP6Net     *net.IPNet
+
+	// EnableSeccomp is supported only for slirp4netns v1.1.0+, and is
+	// independent from createSandbox.
+	EnableSeccomp bool
+
+	// OutboundAddr and OutboundAddr6 are supported only for slirp4netns
+	// v1.1.0+. Each is either an IP address or an interface name, and
+	// binds the source address slirp4netns uses for outbound traffic on
+	// the container's behalf.
+	OutboundAddr  string
+	OutboundAddr6 string
+
+	// EnableReadyFd is supported only for slirp4netns v0.4.0+ (check
+	// Features.SupportsReadyFd before setting this). When true,
+	// ConfigureNetwork hands slirp4netns a --ready-fd and blocks until it
+	// signals readiness instead of returning as soon as the process starts.
+	EnableReadyFd bool
+}
+
 // NewParentDriver instantiates new parent driver.
 // ipnet is supported only for slirp4netns v0.3.0+.
 // ipnet MUST be nil for slirp4netns < v0.3.0.
@@ -62,7 +116,9 @@ func DetectFeatures(binary string) (*Features, error) {
 // disableHostLoopback is supported only for slirp4netns v0.3.0+
 // apiSocketPath is supported only for slirp4netns v0.3.0+
 // createSandbox is supported only for slirp4netns v0.4.0+
-func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopback bool, apiSocketPath string, createSandbox bool) network.ParentDriver {
+//
+// See Opts for the remaining, newer knobs.
+func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopback bool, apiSocketPath string, createSandbox bool, opts Opts) network.ParentDriver {
 	if binary == "" {
 		panic("got empty slirp4netns binary")
 	}
@@ -79,6 +135,12 @@ func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopba
 		disableHostLoopback: disableHostLoopback,
 		apiSocketPath:       apiSocketPath,
 		createSandbox:       createSandbox,
+		enableIPv6:          opts.EnableIPv6,
+		ip6net:              opts.IP6Net,
+		enableSeccomp:       opts.EnableSeccomp,
+		outboundAddr:        opts.OutboundAddr,
+		outboundAddr6:       opts.OutboundAddr6,
+		readyFd:             opts.EnableReadyFd,
 	}
 }
 
@@ -89,6 +151,12 @@ type parentDriver struct {
 	disableHostLoopback bool
 	apiSocketPath       string
 	createSandbox       bool
+	enableIPv6          bool
+	ip6net              *net.IPNet
+	enableSeccomp       bool
+	outboundAddr        string
+	outboundAddr6       string
+	readyFd             bool
 }
 
 func (d *parentDriver) MTU() int {
@@ -115,10 +183,39 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 	if d.createSandbox {
 		opts = append(opts, "--create-sandbox")
 	}
+	if d.enableIPv6 {
+		opts = append(opts, "--enable-ipv6")
+		if d.ip6net != nil {
+			opts = append(opts, "--cidr6", d.ip6net.String())
+		}
+	}
+	if d.enableSeccomp {
+		opts = append(opts, "--enable-seccomp")
+	}
+	if d.outboundAddr != "" {
+		opts = append(opts, "--outbound-addr="+d.outboundAddr)
+	}
+	if d.outboundAddr6 != "" {
+		opts = append(opts, "--outbound-addr6="+d.outboundAddr6)
+	}
+	var readyR, readyW *os.File
+	if d.readyFd {
+		var pipeErr error
+		readyR, readyW, pipeErr = os.Pipe()
+		if pipeErr != nil {
+			cancel()
+			return nil, common.Seq(cleanups), errors.Wrap(pipeErr, "creating ready-fd pipe")
+		}
+		defer readyR.Close()
+		opts = append(opts, "--ready-fd=3")
+	}
 	cmd := exec.CommandContext(ctx, d.binary, append(opts, []string{strconv.Itoa(childPID), tap}...)...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Pdeathsig: syscall.SIGKILL,
 	}
+	if d.readyFd {
+		cmd.ExtraFiles = []*os.File{readyW}
+	}
 	cleanups = append(cleanups, func() error {
 		logrus.Debugf("killing slirp4netns")
 		cancel()
@@ -127,14 +224,24 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 		return nil
 	})
 	if err := cmd.Start(); err != nil {
+		if d.readyFd {
+			readyW.Close()
+		}
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "executing %v", cmd)
 	}
+	if d.readyFd {
+		readyW.Close()
+		if err := waitForReady(ctx, readyR); err != nil {
+			return nil, common.Seq(cleanups), err
+		}
+	}
 	netmsg := common.NetworkMessage{
 		Dev: tap,
 		MTU: d.mtu,
 	}
 	if d.ipnet != nil {
-		// TODO: get the actual configuration via slirp4netns API?
+		// Computed default, overridden below with the slirp4netns-reported
+		// configuration when the api socket and get_config are available.
 		x, err := iputils.AddIPInt(d.ipnet.IP, 100)
 		if err != nil {
 			return nil, common.Seq(cleanups), err
@@ -157,9 +264,148 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 		netmsg.Gateway = "10.0.2.2"
 		netmsg.DNS = "10.0.2.3"
 	}
+	if d.enableIPv6 {
+		if d.ip6net != nil {
+			x, err := iputils.AddIPInt(d.ip6net.IP, 100)
+			if err != nil {
+				return nil, common.Seq(cleanups), err
+			}
+			netmsg.IP6 = x.String()
+			netmsg.Netmask6, _ = d.ip6net.Mask.Size()
+			x, err = iputils.AddIPInt(d.ip6net.IP, 2)
+			if err != nil {
+				return nil, common.Seq(cleanups), err
+			}
+			netmsg.Gateway6 = x.String()
+			x, err = iputils.AddIPInt(d.ip6net.IP, 3)
+			if err != nil {
+				return nil, common.Seq(cleanups), err
+			}
+			netmsg.DNS6 = x.String()
+		} else {
+			// slirp4netns assigns a ULA /64 out of fd00::/8 and a
+			// link-local scoped gateway when no --cidr6 is given.
+			netmsg.IP6 = "fd00::100"
+			netmsg.Netmask6 = 64
+			netmsg.Gateway6 = "fe80::2"
+			netmsg.DNS6 = "fd00::3"
+		}
+	}
+	if d.apiSocketPath != "" {
+		cfg, err := queryConfig(ctx, d.apiSocketPath)
+		if err != nil {
+			logrus.Debugf("could not query slirp4netns for its actual configuration, using computed defaults: %v", err)
+		} else {
+			if cfg.IPv4 != nil {
+				if cfg.IPv4.DHCPStart != "" {
+					netmsg.IP = cfg.IPv4.DHCPStart
+				}
+				if cfg.IPv4.Gateway != "" {
+					netmsg.Gateway = cfg.IPv4.Gateway
+				}
+				if cfg.IPv4.DNS != "" {
+					netmsg.DNS = cfg.IPv4.DNS
+				}
+			}
+			if d.enableIPv6 && cfg.IPv6 != nil {
+				if cfg.IPv6.DHCPStart != "" {
+					netmsg.IP6 = cfg.IPv6.DHCPStart
+				}
+				if cfg.IPv6.Gateway != "" {
+					netmsg.Gateway6 = cfg.IPv6.Gateway
+				}
+				if cfg.IPv6.DNS != "" {
+					netmsg.DNS6 = cfg.IPv6.DNS
+				}
+			}
+		}
+	}
 	return &netmsg, common.Seq(cleanups), nil
 }
 
+// waitForReady blocks until slirp4netns writes a byte to its --ready-fd,
+// signalling that it has attached to the tap and is ready to serve, or
+// until ctx is cancelled or readyFdTimeout elapses.
+func waitForReady(ctx context.Context, r *os.File) error {
+	ctx, cancel := context.WithTimeout(ctx, readyFdTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := r.Read(b)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.Wrap(err, "waiting for slirp4netns to become ready")
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for slirp4netns to become ready")
+	}
+}
+
+// getConfigStack is the per-family section of a get_config response.
+type getConfigStack struct {
+	Host      string `json:"host"`
+	DHCPStart string `json:"dhcp_start"`
+	Gateway   string `json:"gateway"`
+	DNS       string `json:"dns"`
+}
+
+type getConfigResult struct {
+	IPv4 *getConfigStack `json:"ipv4,omitempty"`
+	IPv6 *getConfigStack `json:"ipv6,omitempty"`
+}
+
+// queryConfig asks a running slirp4netns for its actual configuration over
+// its api socket, via the {"execute":"get_config"} command. It returns an
+// error when the socket is unreachable or the slirp4netns build predates
+// get_config, in which case callers should fall back to computed defaults.
+//
+// The round trip is bounded by queryConfigTimeout (derived from ctx), so a
+// slirp4netns that accepts the connection but never answers can't hang
+// ConfigureNetwork forever.
+func queryConfig(ctx context.Context, apiSocketPath string) (*getConfigResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryConfigTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", apiSocketPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to slirp4netns api socket %q", apiSocketPath)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.Wrap(err, "setting deadline on slirp4netns api socket")
+		}
+	}
+	if _, err := conn.Write([]byte(`{"execute":"get_config"}` + "\n")); err != nil {
+		return nil, errors.Wrap(err, "writing get_config request")
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "reading get_config response")
+	}
+	var resp struct {
+		Return *getConfigResult `json:"return,omitempty"`
+		Error  *struct {
+			Desc string `json:"desc"`
+		} `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, errors.Wrapf(err, "unmarshalling get_config response %q", string(line))
+	}
+	if resp.Error != nil {
+		return nil, errors.Errorf("slirp4netns get_config error: %s", resp.Error.Desc)
+	}
+	if resp.Return == nil {
+		return nil, errors.New("empty get_config response")
+	}
+	return resp.Return, nil
+}
+
 func NewChildDriver() network.ChildDriver {
 	return &childDriver{}
 }
@@ -175,5 +421,8 @@ func (d *childDriver) ConfigureNetworkChild(netmsg *common.NetworkMessage) (stri
 	// tap is created and "up".
 	// IP stuff and MTU are not configured by the parent here,
 	// and they are up to the child.
+	//
+	// When netmsg carries IPv6 (IP6/Netmask6/Gateway6/DNS6), the child is
+	// expected to configure it alongside the v4 config, same as above.
 	return tap, nil
 }
@@ -0,0 +1,93 @@
+package slirp4netns
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeAPISocket replies to a single get_config request with body, then
+// closes the connection.
+func fakeAPISocket(t *testing.T, socketPath, body string) {
+	t.Helper()
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := bufio.NewReader(conn).ReadBytes('\n'); err != nil {
+			return
+		}
+		conn.Write([]byte(body + "\n"))
+	}()
+	t.Cleanup(func() { l.Close() })
+}
+
+func TestQueryConfig(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	fakeAPISocket(t, socketPath, `{"return":{"ipv4":{"host":"10.0.2.2","dhcp_start":"10.0.2.100","gateway":"10.0.2.2","dns":"10.0.2.3"},"ipv6":{"host":"fd00::2","dhcp_start":"fd00::100","gateway":"fd00::2","dns":"fd00::3"}}}`)
+	cfg, err := queryConfig(context.Background(), socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IPv4 == nil || cfg.IPv4.DHCPStart != "10.0.2.100" || cfg.IPv4.Gateway != "10.0.2.2" || cfg.IPv4.DNS != "10.0.2.3" {
+		t.Fatalf("unexpected ipv4 config: %+v", cfg.IPv4)
+	}
+	if cfg.IPv6 == nil || cfg.IPv6.DHCPStart != "fd00::100" || cfg.IPv6.Gateway != "fd00::2" || cfg.IPv6.DNS != "fd00::3" {
+		t.Fatalf("unexpected ipv6 config: %+v", cfg.IPv6)
+	}
+}
+
+func TestQueryConfigError(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	fakeAPISocket(t, socketPath, `{"error":{"desc":"unknown command: get_config"}}`)
+	if _, err := queryConfig(context.Background(), socketPath); err == nil {
+		t.Fatal("expected an error for an unsupported get_config command")
+	}
+}
+
+func TestQueryConfigUnreachable(t *testing.T) {
+	if _, err := queryConfig(context.Background(), filepath.Join(t.TempDir(), "does-not-exist.sock")); err == nil {
+		t.Fatal("expected an error dialing a nonexistent socket")
+	}
+}
+
+func TestQueryConfigRespectsContextDeadline(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		l.Close()
+	})
+	go func() {
+		// Accept the connection but never reply, simulating a hung
+		// slirp4netns.
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		<-stop
+		conn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := queryConfig(ctx, socketPath); err == nil {
+		t.Fatal("expected a timeout error from a hung slirp4netns")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("queryConfig took %s to respect a 100ms context deadline", elapsed)
+	}
+}
@@ -0,0 +1,49 @@
+// Package port defines the types shared by RootlessKit's port drivers.
+package port
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Spec is a port to be published from the parent's network namespace into
+// the child's.
+type Spec struct {
+	Proto      string // "tcp" or "udp"
+	ParentIP   string // IP on the parent's network namespace, usually 0.0.0.0
+	ParentPort int
+	ChildIP    string // IP on the child's network namespace
+	ChildPort  int
+}
+
+// Validate returns an error if spec is not a publishable port.
+func (spec *Spec) Validate() error {
+	switch spec.Proto {
+	case "tcp", "udp":
+	default:
+		return errors.Errorf("unknown proto: %q", spec.Proto)
+	}
+	if spec.ParentPort < 1 || spec.ParentPort > 65535 {
+		return errors.Errorf("invalid ParentPort: %d", spec.ParentPort)
+	}
+	if spec.ChildPort < 1 || spec.ChildPort > 65535 {
+		return errors.Errorf("invalid ChildPort: %d", spec.ChildPort)
+	}
+	return nil
+}
+
+// Status is a published Spec, as tracked by a ParentDriver.
+type Status struct {
+	ID int
+	Spec
+}
+
+// ParentDriver is implemented by the parent-side half of a port driver.
+type ParentDriver interface {
+	// OpaqueInfo returns driver-specific info, mostly for debugging.
+	OpaqueInfo() interface{}
+	AddPort(ctx context.Context, spec Spec) (*Status, error)
+	ListPorts(ctx context.Context) ([]Status, error)
+	RemovePort(ctx context.Context, id int) error
+}
@@ -0,0 +1,259 @@
+// Package slirp4netnsapi implements a port.ParentDriver that drives port
+// forwarding through slirp4netns's own JSON control socket (--api-socket),
+// rather than RootlessKit's builtin socket-splicing driver.
+package slirp4netnsapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// NewParentDriver creates a port driver that talks to slirp4netns over the
+// unix socket configured via its `--api-socket` flag.
+//
+// socketPath MUST be the same path passed to slirp4netns's --api-socket.
+func NewParentDriver(socketPath string) port.ParentDriver {
+	if socketPath == "" {
+		panic("got empty slirp4netns api socket path")
+	}
+	return &parentDriver{
+		socketPath: socketPath,
+		ports:      make(map[int]portEntry),
+	}
+}
+
+type portEntry struct {
+	spec    port.Spec
+	slirpID int
+}
+
+type parentDriver struct {
+	socketPath string
+
+	// mu serializes access to conn/connR and ports: slirp4netns's api
+	// socket handles one request/response pair at a time, and nextID
+	// must stay in sync with ports.
+	mu     sync.Mutex
+	conn   net.Conn
+	connR  *bufio.Reader
+	ports  map[int]portEntry
+	nextID int
+}
+
+func (d *parentDriver) OpaqueInfo() interface{} {
+	return nil
+}
+
+type apiRequest struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type addHostfwdArgs struct {
+	Proto     string `json:"proto"`
+	HostAddr  string `json:"host_addr,omitempty"`
+	HostPort  int    `json:"host_port"`
+	GuestAddr string `json:"guest_addr,omitempty"`
+	GuestPort int    `json:"guest_port"`
+}
+
+type removeHostfwdArgs struct {
+	ID int `json:"id"`
+}
+
+type hostfwdEntry struct {
+	ID        int    `json:"id"`
+	Proto     string `json:"proto"`
+	HostAddr  string `json:"host_addr"`
+	HostPort  int    `json:"host_port"`
+	GuestAddr string `json:"guest_addr"`
+	GuestPort int    `json:"guest_port"`
+}
+
+type apiResponse struct {
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  *struct {
+		Desc string `json:"desc"`
+	} `json:"error,omitempty"`
+}
+
+// call sends req over the api socket and returns the decoded response.
+// The caller MUST hold d.mu for the duration of the round trip, since
+// slirp4netns serves exactly one request/response pair at a time.
+//
+// The round trip is bounded by ctx: a deadline on ctx is applied to the
+// connection, and the connection is torn down as soon as ctx is done even
+// if slirp4netns never answers.
+func (d *parentDriver) call(ctx context.Context, req apiRequest) (*apiResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if d.conn == nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "unix", d.socketPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "connecting to slirp4netns api socket %q", d.socketPath)
+		}
+		d.conn = conn
+		d.connR = bufio.NewReader(conn)
+	}
+	conn, connR := d.conn, d.connR
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.Wrap(err, "setting deadline on slirp4netns api socket")
+		}
+	}
+	type result struct {
+		resp *apiResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		b, err := json.Marshal(req)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		logrus.Debugf("slirp4netns api socket: >>> %s", string(b))
+		if _, err := conn.Write(append(b, '\n')); err != nil {
+			done <- result{err: errors.Wrap(err, "writing to slirp4netns api socket")}
+			return
+		}
+		line, err := connR.ReadBytes('\n')
+		if err != nil {
+			done <- result{err: errors.Wrap(err, "reading from slirp4netns api socket")}
+			return
+		}
+		logrus.Debugf("slirp4netns api socket: <<< %s", string(line))
+		var resp apiResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			done <- result{err: errors.Wrapf(err, "unmarshalling slirp4netns api response %q", string(line))}
+			return
+		}
+		if resp.Error != nil {
+			done <- result{err: errors.Errorf("slirp4netns api error: %s", resp.Error.Desc)}
+			return
+		}
+		done <- result{resp: &resp}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			conn.Close()
+			d.conn, d.connR = nil, nil
+		}
+		return r.resp, r.err
+	case <-ctx.Done():
+		conn.Close()
+		d.conn, d.connR = nil, nil
+		return nil, errors.Wrap(ctx.Err(), "waiting for slirp4netns api socket")
+	}
+}
+
+func (d *parentDriver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	resp, err := d.call(ctx, apiRequest{
+		Execute: "add_hostfwd",
+		Arguments: addHostfwdArgs{
+			Proto:     spec.Proto,
+			HostAddr:  spec.ParentIP,
+			HostPort:  spec.ParentPort,
+			GuestAddr: spec.ChildIP,
+			GuestPort: spec.ChildPort,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "adding hostfwd for %+v", spec)
+	}
+	var ret struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(resp.Return, &ret); err != nil {
+		return nil, errors.Wrapf(err, "unmarshalling add_hostfwd return %q", string(resp.Return))
+	}
+	id := d.nextID
+	d.nextID++
+	d.ports[id] = portEntry{spec: spec, slirpID: ret.ID}
+	st := port.Status{
+		ID:   id,
+		Spec: spec,
+	}
+	return &st, nil
+}
+
+func (d *parentDriver) ListPorts(ctx context.Context) ([]port.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.reconcile(ctx); err != nil {
+		return nil, err
+	}
+	var ports []port.Status
+	for id, entry := range d.ports {
+		ports = append(ports, port.Status{
+			ID:   id,
+			Spec: entry.spec,
+		})
+	}
+	return ports, nil
+}
+
+// reconcile rebuilds d.ports from slirp4netns's live hostfwd table when our
+// in-memory state is empty, e.g. right after RootlessKit restarts against
+// an already-running slirp4netns.
+func (d *parentDriver) reconcile(ctx context.Context) error {
+	if len(d.ports) > 0 {
+		return nil
+	}
+	resp, err := d.call(ctx, apiRequest{Execute: "list_hostfwd"})
+	if err != nil {
+		return errors.Wrap(err, "listing hostfwd")
+	}
+	var entries []hostfwdEntry
+	if err := json.Unmarshal(resp.Return, &entries); err != nil {
+		return errors.Wrapf(err, "unmarshalling list_hostfwd return %q", string(resp.Return))
+	}
+	for _, e := range entries {
+		id := d.nextID
+		d.nextID++
+		d.ports[id] = portEntry{
+			slirpID: e.ID,
+			spec: port.Spec{
+				Proto:      e.Proto,
+				ParentIP:   e.HostAddr,
+				ParentPort: e.HostPort,
+				ChildIP:    e.GuestAddr,
+				ChildPort:  e.GuestPort,
+			},
+		}
+	}
+	return nil
+}
+
+func (d *parentDriver) RemovePort(ctx context.Context, id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.ports[id]
+	if !ok {
+		return errors.Errorf("unknown port id: %d", id)
+	}
+	if _, err := d.call(ctx, apiRequest{
+		Execute:   "remove_hostfwd",
+		Arguments: removeHostfwdArgs{ID: entry.slirpID},
+	}); err != nil {
+		return errors.Wrapf(err, "removing hostfwd %+v", entry.spec)
+	}
+	delete(d.ports, id)
+	return nil
+}
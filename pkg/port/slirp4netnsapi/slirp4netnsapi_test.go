@@ -0,0 +1,165 @@
+package slirp4netnsapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// fakeSlirp4netns serves a subset of slirp4netns's JSON api socket protocol
+// for a single connection, driven by handle.
+func fakeSlirp4netns(t *testing.T, socketPath string, handle func(req map[string]interface{}) interface{}) {
+	t.Helper()
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var req map[string]interface{}
+			if err := json.Unmarshal(line, &req); err != nil {
+				return
+			}
+			resp, err := json.Marshal(handle(req))
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(append(resp, '\n')); err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+}
+
+func TestAddAndRemovePort(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	var lastExecute string
+	fakeSlirp4netns(t, socketPath, func(req map[string]interface{}) interface{} {
+		lastExecute = req["execute"].(string)
+		switch lastExecute {
+		case "add_hostfwd":
+			return map[string]interface{}{"return": map[string]interface{}{"id": 42}}
+		case "remove_hostfwd":
+			return map[string]interface{}{"return": map[string]interface{}{}}
+		default:
+			return map[string]interface{}{"error": map[string]interface{}{"desc": "unexpected command"}}
+		}
+	})
+	d := NewParentDriver(socketPath)
+	ctx := context.Background()
+	st, err := d.AddPort(ctx, port.Spec{
+		Proto:      "tcp",
+		ParentIP:   "0.0.0.0",
+		ParentPort: 8080,
+		ChildIP:    "10.0.2.100",
+		ChildPort:  80,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastExecute != "add_hostfwd" {
+		t.Fatalf("expected add_hostfwd, got %q", lastExecute)
+	}
+	if err := d.RemovePort(ctx, st.ID); err != nil {
+		t.Fatal(err)
+	}
+	if lastExecute != "remove_hostfwd" {
+		t.Fatalf("expected remove_hostfwd, got %q", lastExecute)
+	}
+	if err := d.RemovePort(ctx, st.ID); err == nil {
+		t.Fatal("expected error removing an already-removed port id")
+	}
+}
+
+func TestAddPortError(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	fakeSlirp4netns(t, socketPath, func(req map[string]interface{}) interface{} {
+		return map[string]interface{}{"error": map[string]interface{}{"desc": "hostfwd already in use"}}
+	})
+	d := NewParentDriver(socketPath)
+	if _, err := d.AddPort(context.Background(), port.Spec{
+		Proto:      "tcp",
+		ParentPort: 8080,
+		ChildPort:  80,
+	}); err == nil {
+		t.Fatal("expected error from add_hostfwd")
+	}
+}
+
+func TestAddPortRespectsContextDeadline(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		l.Close()
+	})
+	go func() {
+		// Accept the connection but never reply, simulating a hung
+		// slirp4netns.
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		<-stop
+		conn.Close()
+	}()
+	d := NewParentDriver(socketPath)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = d.AddPort(ctx, port.Spec{
+		Proto:      "tcp",
+		ParentPort: 8080,
+		ChildPort:  80,
+	})
+	if err == nil {
+		t.Fatal("expected a timeout error from a hung slirp4netns")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("AddPort took %s to respect a 100ms context deadline", elapsed)
+	}
+}
+
+func TestListPortsReconciles(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	fakeSlirp4netns(t, socketPath, func(req map[string]interface{}) interface{} {
+		if req["execute"] != "list_hostfwd" {
+			return map[string]interface{}{"error": map[string]interface{}{"desc": "unexpected command"}}
+		}
+		return map[string]interface{}{"return": []map[string]interface{}{
+			{"id": 7, "proto": "tcp", "host_addr": "0.0.0.0", "host_port": 2222, "guest_addr": "10.0.2.100", "guest_port": 22},
+		}}
+	})
+	d := NewParentDriver(socketPath)
+	ports, err := d.ListPorts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 {
+		t.Fatalf("expected 1 port, got %d", len(ports))
+	}
+	if ports[0].ParentPort != 2222 || ports[0].ChildPort != 22 {
+		t.Fatalf("unexpected reconciled port: %+v", ports[0])
+	}
+}